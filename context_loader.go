@@ -0,0 +1,85 @@
+package dynamictls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+)
+
+// buildContextCertState builds a certState around lf instead of a static
+// Loader. No certificate is loaded up front: lf is invoked fresh for every
+// handshake via tls.Config.GetClientCertificate, so the resulting
+// *http.Transport has no fixed cert or leaf of its own and is never
+// invalidated by certificate rotation. DialTLSContext is set so the
+// request's context reaches the handshake, letting lf see its deadline and
+// cancellation and letting a canceled request abort mid-handshake instead of
+// blocking until the dial's own timeout.
+func (t *Transport) buildContextCertState(which string, lf LoaderFunc) (*certState, error) {
+	var baseTLS *tls.Config
+	if t.baseTLS != nil {
+		baseTLS = t.baseTLS.Clone()
+	} else {
+		baseTLS = &tls.Config{}
+	}
+
+	var rootsPool *x509.CertPool
+	if t.rootCALoader != nil {
+		pool, err := t.rootCALoader()
+		if err != nil {
+			return nil, fmt.Errorf("load root CAs: %w", err)
+		}
+		baseTLS.RootCAs = pool
+		rootsPool = pool
+	}
+
+	if t.enableHTTP2 {
+		if len(t.nextProtos) > 0 {
+			baseTLS.NextProtos = t.nextProtos
+		} else {
+			baseTLS.NextProtos = []string{"h2", "http/1.1"}
+		}
+	}
+
+	baseTLS.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		// CertificateRequestInfo carries no ClientHelloInfo of its own, only
+		// a context; hello is always nil on this path.
+		cert, err := lf(cri.Context(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("load %s certificate: %w", which, err)
+		}
+		return cert, nil
+	}
+
+	transport, err := t.newTransport(baseTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{},
+		Config:    baseTLS,
+	}
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if t.dialContext == nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		rawConn, err := t.dialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg := baseTLS.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		conn := tls.Client(rawConn, cfg)
+		if err := conn.HandshakeContext(ctx); err != nil {
+			_ = rawConn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	return &certState{transport: transport, rootsPool: rootsPool}, nil
+}
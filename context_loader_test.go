@@ -0,0 +1,129 @@
+package dynamictls_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vladyslavpavlenko/dynamictls"
+)
+
+func TestTransport_LoaderFunc(main *testing.T) {
+	main.Run("PropagatesRequestContext", func(t *testing.T) {
+		var gotCtx context.Context
+
+		pLoaderFunc := func(ctx context.Context, hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			gotCtx = ctx
+			return generateTLSKeyPair()
+		}
+
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoaderFunc:   pLoaderFunc,
+			SecondaryLoaderFunc: pLoaderFunc,
+			BaseTLS:             &tls.Config{InsecureSkipVerify: true},
+		})
+
+		server := httptest.NewUnstartedServer(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		tlsCert, err := generateTLSKeyPair()
+		require.NoError(t, err)
+		server.TLS = &tls.Config{
+			Certificates: []tls.Certificate{*tlsCert},
+			// GetClientCertificate (which LoaderFunc backs) is only invoked
+			// when the server requests a client certificate during the
+			// handshake.
+			ClientAuth: tls.RequestClientCert,
+		}
+		server.StartTLS()
+		t.Cleanup(server.Close)
+
+		type ctxKey struct{}
+		ctx := context.WithValue(context.Background(), ctxKey{}, "tenant-a")
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		require.NotNil(t, gotCtx, "pLoaderFunc should have been reached via GetClientCertificate")
+		assert.Equal(t, "tenant-a", gotCtx.Value(ctxKey{}))
+	})
+
+	main.Run("CancelAbortsHandshakePromptly", func(t *testing.T) {
+		var calls atomic.Int32
+		entered := make(chan struct{}, 1)
+
+		// pLoaderFunc blocks on ctx.Done() so the test can prove the
+		// handshake was actually in flight (entered fires) before it cancels
+		// the request, rather than racing a dial that fails before TLS even
+		// starts.
+		pLoaderFunc := func(ctx context.Context, hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			calls.Add(1)
+			select {
+			case entered <- struct{}{}:
+			default:
+			}
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoaderFunc:   pLoaderFunc,
+			SecondaryLoaderFunc: pLoaderFunc,
+			BaseTLS:             &tls.Config{InsecureSkipVerify: true},
+		})
+
+		server := httptest.NewUnstartedServer(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		tlsCert, err := generateTLSKeyPair()
+		require.NoError(t, err)
+		server.TLS = &tls.Config{
+			Certificates: []tls.Certificate{*tlsCert},
+			// Requesting a client cert is what drives GetClientCertificate
+			// (and so pLoaderFunc) during the handshake, as in
+			// PropagatesRequestContext above.
+			ClientAuth: tls.RequestClientCert,
+		}
+		server.StartTLS()
+		t.Cleanup(server.Close)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+
+		done := make(chan struct{})
+		go func() {
+			_, _ = transport.RoundTrip(req)
+			close(done)
+		}()
+
+		select {
+		case <-entered:
+		case <-time.After(5 * time.Second):
+			t.Fatal("pLoaderFunc was never entered; the handshake never reached GetClientCertificate")
+		}
+		require.Equal(t, int32(1), calls.Load())
+
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("RoundTrip did not abort after context cancellation")
+		}
+	})
+}
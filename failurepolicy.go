@@ -0,0 +1,253 @@
+package dynamictls
+
+import (
+	"sync"
+	"time"
+)
+
+// Which identifies a loader slot.
+type Which string
+
+const (
+	WhichPrimary   Which = "primary"
+	WhichSecondary Which = "secondary"
+)
+
+// FailurePolicy decides, on every RoundTrip, whether the secondary loader
+// should be preferred over the primary, based on results it's told about via
+// OnResult. The zero Config gets [newCircuitBreaker], a proper circuit
+// breaker with exponential backoff and a half-open probe state; implement
+// FailurePolicy directly for custom failover logic (e.g. preferring
+// secondary based on an external health signal).
+type FailurePolicy interface {
+	// OnResult records the outcome of trying which. err is nil on success.
+	OnResult(which Which, err error)
+
+	// ShouldPreferSecondary reports whether RoundTrip should try the
+	// secondary loader before the primary.
+	ShouldPreferSecondary() bool
+
+	// NextProbe returns how long until the policy will next allow a primary
+	// probe while it's steering traffic to the secondary. Zero means now.
+	// Surfaced on Stats.NextProbe for dashboards and alerts.
+	NextProbe() time.Duration
+}
+
+// circuitState is the public face of a circuitBreaker's internal state, for
+// Stats and dashboards.
+type circuitState string
+
+const (
+	circuitClosed   circuitState = "closed"
+	circuitOpen     circuitState = "open"
+	circuitHalfOpen circuitState = "half_open"
+)
+
+// CircuitBreakerConfig tunes [newCircuitBreaker], the default FailurePolicy.
+type CircuitBreakerConfig struct {
+	// ConsecutiveFailures is the number of consecutive primary failures that
+	// trip the breaker open. The default is 3, matching the historical
+	// Transport.threshold.
+	ConsecutiveFailures uint32
+
+	// ErrorRateThreshold additionally trips the breaker open when the
+	// fraction of failures over the last ErrorRateWindow primary results
+	// exceeds this value, even if failures weren't all consecutive. It's
+	// only evaluated once at least ConsecutiveFailures results have been
+	// recorded, so a single failure can't be a 100% error rate and trip the
+	// breaker ahead of ConsecutiveFailures. The default is 0.5 (more than
+	// half).
+	ErrorRateThreshold float64
+
+	// ErrorRateWindow is the number of recent primary results considered by
+	// ErrorRateThreshold. The default is 20.
+	ErrorRateWindow int
+
+	// BaseBackoff is how long the breaker stays open before admitting a
+	// half-open probe, the first time it trips. The default is 1s.
+	BaseBackoff time.Duration
+
+	// MaxBackoff caps the exponential backoff applied each time a half-open
+	// probe fails. The default is 30s.
+	MaxBackoff time.Duration
+}
+
+func (c CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if c.ConsecutiveFailures == 0 {
+		c.ConsecutiveFailures = threshold
+	}
+	if c.ErrorRateThreshold == 0 {
+		c.ErrorRateThreshold = 0.5
+	}
+	if c.ErrorRateWindow == 0 {
+		c.ErrorRateWindow = 20
+	}
+	if c.BaseBackoff == 0 {
+		c.BaseBackoff = time.Second
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = 30 * time.Second
+	}
+	return c
+}
+
+// circuitBreaker is the default FailurePolicy. Only primary results drive the
+// breaker: a flapping primary trips it open, after which traffic is steered
+// to the secondary until a half-open probe against the primary succeeds.
+type circuitBreaker struct {
+	cfg CircuitBreakerConfig
+
+	mu                  sync.Mutex
+	state               circuitState
+	consecutiveFailures uint32
+	backoff             time.Duration
+	openedAt            time.Time
+	probing             bool
+	window              []bool // recent primary results, true = success
+}
+
+// newCircuitBreaker builds the default FailurePolicy from cfg.
+func newCircuitBreaker(cfg CircuitBreakerConfig) *circuitBreaker {
+	cfg = cfg.withDefaults()
+	return &circuitBreaker{
+		cfg:     cfg,
+		state:   circuitClosed,
+		backoff: cfg.BaseBackoff,
+	}
+}
+
+func (cb *circuitBreaker) OnResult(which Which, err error) {
+	if which != WhichPrimary {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.window = append(cb.window, err == nil)
+	if len(cb.window) > cb.cfg.ErrorRateWindow {
+		cb.window = cb.window[len(cb.window)-cb.cfg.ErrorRateWindow:]
+	}
+
+	if err == nil {
+		cb.consecutiveFailures = 0
+		cb.state = circuitClosed
+		cb.backoff = cb.cfg.BaseBackoff
+		cb.probing = false
+		return
+	}
+
+	cb.consecutiveFailures++
+	cb.probing = false
+
+	switch cb.state {
+	case circuitHalfOpen:
+		// The probe failed: reopen with a longer backoff.
+		cb.state = circuitOpen
+		cb.openedAt = time.Now()
+		cb.backoff = min(cb.backoff*2, cb.cfg.MaxBackoff)
+	case circuitClosed:
+		tripOnRate := len(cb.window) >= int(cb.cfg.ConsecutiveFailures) && cb.errorRateLocked() > cb.cfg.ErrorRateThreshold
+		if cb.consecutiveFailures >= cb.cfg.ConsecutiveFailures || tripOnRate {
+			cb.state = circuitOpen
+			cb.openedAt = time.Now()
+		}
+	}
+}
+
+func (cb *circuitBreaker) errorRateLocked() float64 {
+	if len(cb.window) == 0 {
+		return 0
+	}
+	failures := 0
+	for _, ok := range cb.window {
+		if !ok {
+			failures++
+		}
+	}
+	return float64(failures) / float64(len(cb.window))
+}
+
+func (cb *circuitBreaker) ShouldPreferSecondary() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitClosed:
+		return false
+	case circuitHalfOpen:
+		// A probe is already in flight; everyone else keeps using secondary.
+		return true
+	default: // circuitOpen
+		if time.Since(cb.openedAt) < cb.backoff || cb.probing {
+			return true
+		}
+		// Admit exactly one half-open probe against the primary.
+		cb.state = circuitHalfOpen
+		cb.probing = true
+		return false
+	}
+}
+
+func (cb *circuitBreaker) NextProbe() time.Duration {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state != circuitOpen {
+		return 0
+	}
+	if d := cb.backoff - time.Since(cb.openedAt); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (cb *circuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return string(cb.state)
+}
+
+// stater is implemented by FailurePolicy implementations that can report a
+// human-readable circuit state; newCircuitBreaker's does. Custom policies
+// don't have to implement it.
+type stater interface {
+	State() string
+}
+
+// Stats is a snapshot of Transport's per-loader outcome counts and the
+// FailurePolicy's current state, suitable for exporting as Prometheus-style
+// gauges/counters.
+type Stats struct {
+	PrimarySuccesses   uint64
+	PrimaryFailures    uint64
+	SecondarySuccesses uint64
+	SecondaryFailures  uint64
+
+	// CircuitState is the FailurePolicy's state (e.g. "closed", "open",
+	// "half_open" for the default circuit breaker), or "" if the configured
+	// FailurePolicy doesn't expose one.
+	CircuitState string
+
+	// NextProbe is FailurePolicy.NextProbe's current value: how long until a
+	// primary probe is next allowed while the policy is steering traffic to
+	// the secondary. Zero when the policy isn't currently steering away from
+	// the primary.
+	NextProbe time.Duration
+}
+
+// Stats returns a snapshot of Transport's current failover counters and
+// circuit state.
+func (t *Transport) Stats() Stats {
+	stats := Stats{
+		PrimarySuccesses:   t.pSuccesses.Load(),
+		PrimaryFailures:    t.pFailures.Load(),
+		SecondarySuccesses: t.sSuccesses.Load(),
+		SecondaryFailures:  t.sFailures.Load(),
+	}
+	if s, ok := t.failurePolicy.(stater); ok {
+		stats.CircuitState = s.State()
+	}
+	stats.NextProbe = t.failurePolicy.NextProbe()
+	return stats
+}
@@ -0,0 +1,202 @@
+package dynamictls_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vladyslavpavlenko/dynamictls"
+)
+
+func TestTransport_Stats(main *testing.T) {
+	main.Run("TracksPerLoaderCountsAndCircuitState", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		tlsCert, err := generateTLSKeyPair()
+		require.NoError(t, err)
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{*tlsCert}}
+		server.StartTLS()
+		t.Cleanup(server.Close)
+
+		pLoader := func() (*tls.Certificate, error) {
+			return nil, errors.New("primary unavailable")
+		}
+		sLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoader:   pLoader,
+			SecondaryLoader: sLoader,
+			BaseTLS:         &tls.Config{InsecureSkipVerify: true},
+			CircuitBreaker:  dynamictls.CircuitBreakerConfig{ConsecutiveFailures: 2},
+		})
+
+		for range 2 {
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+			require.NoError(t, err)
+			resp, err := transport.RoundTrip(req)
+			require.NoError(t, err)
+			require.NoError(t, resp.Body.Close())
+		}
+
+		stats := transport.Stats()
+		assert.Equal(t, uint64(2), stats.PrimaryFailures)
+		assert.Equal(t, uint64(0), stats.PrimarySuccesses)
+		assert.GreaterOrEqual(t, stats.SecondarySuccesses, uint64(1))
+		assert.Equal(t, "open", stats.CircuitState)
+	})
+}
+
+func TestTransport_StatsWithLogger(main *testing.T) {
+	main.Run("CircuitStateSurvivesLoggerWrapping", func(t *testing.T) {
+		pLoader := func() (*tls.Certificate, error) {
+			return nil, errors.New("primary unavailable")
+		}
+		sLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+
+		server := httptest.NewUnstartedServer(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		tlsCert, err := generateTLSKeyPair()
+		require.NoError(t, err)
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{*tlsCert}}
+		server.StartTLS()
+		t.Cleanup(server.Close)
+
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoader:   pLoader,
+			SecondaryLoader: sLoader,
+			BaseTLS:         &tls.Config{InsecureSkipVerify: true},
+			CircuitBreaker:  dynamictls.CircuitBreakerConfig{ConsecutiveFailures: 1},
+			Logger:          slog.New(slog.NewTextHandler(io.Discard, nil)),
+		})
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		// With a Logger configured, t.failurePolicy is wrapped in a
+		// loggingPolicy; Stats should still surface the wrapped circuit
+		// breaker's state instead of silently coming back "".
+		assert.Equal(t, "open", transport.Stats().CircuitState)
+	})
+}
+
+func TestDefaultFailurePolicy(main *testing.T) {
+	main.Run("NextProbeCountsDownWhileOpenThenZeroes", func(t *testing.T) {
+		pLoader := func() (*tls.Certificate, error) {
+			return nil, errors.New("primary down")
+		}
+		sLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+
+		server := httptest.NewUnstartedServer(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		tlsCert, err := generateTLSKeyPair()
+		require.NoError(t, err)
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{*tlsCert}}
+		server.StartTLS()
+		t.Cleanup(server.Close)
+
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoader:   pLoader,
+			SecondaryLoader: sLoader,
+			BaseTLS:         &tls.Config{InsecureSkipVerify: true},
+			CircuitBreaker: dynamictls.CircuitBreakerConfig{
+				ConsecutiveFailures: 1,
+				BaseBackoff:         30 * time.Millisecond,
+			},
+		})
+
+		assert.Equal(t, time.Duration(0), transport.Stats().NextProbe, "a closed breaker has nothing to probe for")
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		require.Equal(t, "open", transport.Stats().CircuitState)
+		nextProbe := transport.Stats().NextProbe
+		assert.Greater(t, nextProbe, time.Duration(0))
+		assert.LessOrEqual(t, nextProbe, 30*time.Millisecond)
+
+		time.Sleep(40 * time.Millisecond)
+		assert.Equal(t, time.Duration(0), transport.Stats().NextProbe, "backoff elapsed, a probe should now be due")
+	})
+
+	main.Run("HalfOpenAdmitsOneProbeAfterBackoff", func(t *testing.T) {
+		pFailing := true
+		pLoader := func() (*tls.Certificate, error) {
+			if pFailing {
+				return nil, errors.New("primary down")
+			}
+			return generateTLSKeyPair()
+		}
+		sLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoader:   pLoader,
+			SecondaryLoader: sLoader,
+			BaseTLS:         &tls.Config{InsecureSkipVerify: true},
+			CircuitBreaker: dynamictls.CircuitBreakerConfig{
+				ConsecutiveFailures: 1,
+				BaseBackoff:         10 * time.Millisecond,
+			},
+		})
+
+		server := httptest.NewUnstartedServer(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		tlsCert, err := generateTLSKeyPair()
+		require.NoError(t, err)
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{*tlsCert}}
+		server.StartTLS()
+		t.Cleanup(server.Close)
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		assert.Equal(t, "open", transport.Stats().CircuitState)
+
+		pFailing = false
+		transport.RefreshCertificates()
+		time.Sleep(20 * time.Millisecond)
+
+		req2, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+		resp2, err := transport.RoundTrip(req2)
+		require.NoError(t, err)
+		require.NoError(t, resp2.Body.Close())
+
+		assert.Equal(t, "closed", transport.Stats().CircuitState)
+	})
+}
@@ -0,0 +1,114 @@
+package dynamictls
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Metrics receives counters and observations from a Transport for export as
+// Prometheus-style gauges/counters. All methods must be safe for concurrent
+// use, since RoundTrip may call them from many goroutines at once.
+type Metrics interface {
+	// IncCertLoad counts a certificate (re)load. which is "primary" or
+	// "secondary"; outcome is "success" or "failure".
+	IncCertLoad(which, outcome string)
+
+	// ObserveHandshake records how long building a fresh certificate's
+	// *http.Transport and completing its first RoundTrip on it took. Only
+	// called on the client (RoundTrip) path, where a request actually follows
+	// the build; the server-side Certificate path calls the loaders directly
+	// and the proactive rotation loop in rotation.go builds a certState with
+	// nothing to time it against, so neither calls it. This is an approximation of handshake latency,
+	// not an isolated measurement of the TLS handshake itself: the stdlib
+	// doesn't expose a narrower hook without wrapping net.Conn.
+	ObserveHandshake(which string, d time.Duration)
+
+	// IncFailover counts a RoundTrip (or server-side Certificate call) that
+	// fell back to the second loader after the first failed.
+	IncFailover()
+
+	// SetCertExpiry reports the NotAfter of the most recently loaded
+	// certificate for which, for cert-age dashboards and alerts.
+	SetCertExpiry(which string, t time.Time)
+}
+
+// loggingPolicy wraps a FailurePolicy to log circuit state transitions (e.g.
+// closed -> open on a threshold trip) through logger. It's installed by New
+// when Config.Logger is set.
+type loggingPolicy struct {
+	FailurePolicy
+	logger *slog.Logger
+}
+
+func (p *loggingPolicy) OnResult(which Which, err error) {
+	before := p.state()
+	p.FailurePolicy.OnResult(which, err)
+	if after := p.state(); after != "" && after != before {
+		p.logger.Info("dynamictls: circuit breaker state change",
+			"which", which, "from", before, "to", after)
+	}
+}
+
+func (p *loggingPolicy) state() string {
+	if s, ok := p.FailurePolicy.(stater); ok {
+		return s.State()
+	}
+	return ""
+}
+
+// State implements stater so Stats can still report the wrapped policy's
+// circuit state when Config.Logger is set. loggingPolicy embeds the
+// FailurePolicy interface rather than a concrete type, so circuitBreaker's
+// State method isn't promoted; without this override, t.failurePolicy.(stater)
+// would fail the moment a Logger is configured.
+func (p *loggingPolicy) State() string {
+	return p.state()
+}
+
+// recordCertLoad reports the outcome of a buildCertState call to t.metrics
+// and t.logger, if configured.
+func (t *Transport) recordCertLoad(which string, cs *certState, err error) {
+	if t.metrics != nil {
+		outcome := "success"
+		if err != nil {
+			outcome = "failure"
+		}
+		t.metrics.IncCertLoad(which, outcome)
+		if err == nil && cs.leaf != nil {
+			t.metrics.SetCertExpiry(which, cs.leaf.NotAfter)
+		}
+	}
+
+	if t.logger == nil {
+		return
+	}
+	if err != nil {
+		t.logger.Error("dynamictls: certificate load failed", "which", which, "error", err)
+		return
+	}
+	if cs.leaf != nil {
+		t.logger.Info("dynamictls: certificate loaded", "which", which, "not_after", cs.leaf.NotAfter)
+	} else {
+		t.logger.Info("dynamictls: certificate loaded", "which", which)
+	}
+}
+
+// recordHandshake reports to t.metrics, if configured, how long it took to
+// build a fresh certState for which and complete the first RoundTrip against
+// it. Only called from doUncounted's rebuild path; see Metrics.ObserveHandshake.
+func (t *Transport) recordHandshake(which string, d time.Duration) {
+	if t.metrics != nil {
+		t.metrics.ObserveHandshake(which, d)
+	}
+}
+
+// recordFailover reports that a RoundTrip (or server-side Certificate call)
+// fell back from first to second after first failed.
+func (t *Transport) recordFailover(first, second Which, err error) {
+	if t.metrics != nil {
+		t.metrics.IncFailover()
+	}
+	if t.logger != nil {
+		t.logger.Warn("dynamictls: failing over", "from", first, "to", second, "error", err)
+	}
+}
@@ -0,0 +1,196 @@
+package dynamictls_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vladyslavpavlenko/dynamictls"
+)
+
+// fakeMetrics records the calls made to it for assertions, guarded by a mutex
+// since RoundTrip may call it from many goroutines.
+type fakeMetrics struct {
+	mu                 sync.Mutex
+	certLoads          []string // "which:outcome"
+	handshakes         int
+	handshakeDurations []time.Duration
+	failovers          int
+	expirySet          []string
+}
+
+func (m *fakeMetrics) IncCertLoad(which, outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.certLoads = append(m.certLoads, which+":"+outcome)
+}
+
+func (m *fakeMetrics) ObserveHandshake(_ string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handshakes++
+	m.handshakeDurations = append(m.handshakeDurations, d)
+}
+
+func (m *fakeMetrics) IncFailover() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failovers++
+}
+
+func (m *fakeMetrics) SetCertExpiry(which string, _ time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expirySet = append(m.expirySet, which)
+}
+
+func TestTransport_Observability(main *testing.T) {
+	main.Run("RecordsCertLoadHandshakeAndExpiry", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		tlsCert, err := generateTLSKeyPair()
+		require.NoError(t, err)
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{*tlsCert}}
+		server.StartTLS()
+		t.Cleanup(server.Close)
+
+		metrics := &fakeMetrics{}
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoader: func() (*tls.Certificate, error) {
+				return generateTLSKeyPair()
+			},
+			SecondaryLoader: func() (*tls.Certificate, error) {
+				return generateTLSKeyPair()
+			},
+			BaseTLS: &tls.Config{InsecureSkipVerify: true},
+			Metrics: metrics,
+		})
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+		assert.Contains(t, metrics.certLoads, "primary:success")
+		assert.Equal(t, 1, metrics.handshakes)
+		assert.Contains(t, metrics.expirySet, "primary")
+		assert.Equal(t, 0, metrics.failovers)
+	})
+
+	main.Run("HandshakeDurationCoversFirstRoundTrip", func(t *testing.T) {
+		const handlerDelay = 150 * time.Millisecond
+
+		server := httptest.NewUnstartedServer(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				time.Sleep(handlerDelay)
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		tlsCert, err := generateTLSKeyPair()
+		require.NoError(t, err)
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{*tlsCert}}
+		server.StartTLS()
+		t.Cleanup(server.Close)
+
+		metrics := &fakeMetrics{}
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoader: func() (*tls.Certificate, error) {
+				return generateTLSKeyPair()
+			},
+			SecondaryLoader: func() (*tls.Certificate, error) {
+				return generateTLSKeyPair()
+			},
+			BaseTLS: &tls.Config{InsecureSkipVerify: true},
+			Metrics: metrics,
+		})
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+		require.Len(t, metrics.handshakeDurations, 1)
+		assert.GreaterOrEqual(t, metrics.handshakeDurations[0], handlerDelay,
+			"ObserveHandshake should cover the first RoundTrip, not just building the certState")
+	})
+
+	main.Run("RecordsFailoverOnPrimaryFailure", func(t *testing.T) {
+		server := httptest.NewUnstartedServer(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		tlsCert, err := generateTLSKeyPair()
+		require.NoError(t, err)
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{*tlsCert}}
+		server.StartTLS()
+		t.Cleanup(server.Close)
+
+		metrics := &fakeMetrics{}
+		var logged bytesLogger
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoader: func() (*tls.Certificate, error) {
+				return nil, errors.New("primary unavailable")
+			},
+			SecondaryLoader: func() (*tls.Certificate, error) {
+				return generateTLSKeyPair()
+			},
+			BaseTLS: &tls.Config{InsecureSkipVerify: true},
+			Metrics: metrics,
+			Logger:  slog.New(&logged),
+		})
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		metrics.mu.Lock()
+		assert.Equal(t, 1, metrics.failovers)
+		assert.Contains(t, metrics.certLoads, "primary:failure")
+		assert.Contains(t, metrics.certLoads, "secondary:success")
+		metrics.mu.Unlock()
+
+		logged.mu.Lock()
+		defer logged.mu.Unlock()
+		assert.NotEmpty(t, logged.records)
+	})
+}
+
+// bytesLogger is a minimal slog.Handler that records whether any record was
+// emitted, so tests can assert structured logging happened without asserting
+// on exact message text.
+type bytesLogger struct {
+	mu      sync.Mutex
+	records []string
+}
+
+func (l *bytesLogger) Enabled(context.Context, slog.Level) bool { return true }
+
+func (l *bytesLogger) Handle(_ context.Context, r slog.Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, r.Message)
+	return nil
+}
+
+func (l *bytesLogger) WithAttrs([]slog.Attr) slog.Handler { return l }
+func (l *bytesLogger) WithGroup(string) slog.Handler      { return l }
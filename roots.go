@@ -0,0 +1,16 @@
+package dynamictls
+
+import "crypto/x509"
+
+// poolsEqual reports whether two root CA pools are equivalent, used to detect
+// root rotation without re-parsing or diffing the pool's certificates on
+// every RoundTrip. It's built on CertPool.Equal rather than the deprecated
+// CertPool.Subjects, which its own godoc warns omits a SystemCertPool-derived
+// pool's system roots; a fingerprint built from Subjects would never notice
+// an OS trust-store update to such a pool.
+func poolsEqual(a, b *x509.CertPool) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(b)
+}
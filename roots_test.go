@@ -0,0 +1,165 @@
+package dynamictls_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vladyslavpavlenko/dynamictls"
+)
+
+func TestTransport_RootRotation(main *testing.T) {
+	main.Run("RoundTripReusesTransportWhileRootsUnchanged", func(t *testing.T) {
+		tlsCert, err := generateTLSKeyPair()
+		require.NoError(t, err)
+
+		server := httptest.NewUnstartedServer(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{*tlsCert}}
+		server.Config.ErrorLog = log.New(io.Discard, "", 0)
+		server.StartTLS()
+		t.Cleanup(server.Close)
+
+		loadCount := 0
+		rootCALoader := func() (*x509.CertPool, error) {
+			loadCount++
+			return x509.NewCertPool(), nil
+		}
+
+		pLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+		sLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoader:   pLoader,
+			SecondaryLoader: sLoader,
+			BaseTLS:         &tls.Config{InsecureSkipVerify: true},
+			RootCALoader:    rootCALoader,
+		})
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		firstLoadCount := loadCount
+
+		req2, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+		resp2, err := transport.RoundTrip(req2)
+		require.NoError(t, err)
+		require.NoError(t, resp2.Body.Close())
+
+		// Two cert pools with no certs compare equal, so the cached
+		// transport should have been reused, not rebuilt.
+		assert.Equal(t, firstLoadCount+1, loadCount)
+
+		transport.RefreshRoots()
+
+		req3, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+		resp3, err := transport.RoundTrip(req3)
+		require.NoError(t, err)
+		require.NoError(t, resp3.Body.Close())
+	})
+
+	main.Run("RoundTripRebuildsOnSystemCertPoolDerivedChange", func(t *testing.T) {
+		base, err := x509.SystemCertPool()
+		if err != nil {
+			t.Skip("no system cert pool available in this environment")
+		}
+
+		tlsCert, err := generateTLSKeyPair()
+		require.NoError(t, err)
+
+		cert1, err := generateTLSKeyPair()
+		require.NoError(t, err)
+		leaf1, err := x509.ParseCertificate(cert1.Certificate[0])
+		require.NoError(t, err)
+
+		cert2, err := generateTLSKeyPair()
+		require.NoError(t, err)
+		leaf2, err := x509.ParseCertificate(cert2.Certificate[0])
+		require.NoError(t, err)
+
+		server := httptest.NewUnstartedServer(
+			http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+		)
+		server.TLS = &tls.Config{Certificates: []tls.Certificate{*tlsCert}}
+		server.Config.ErrorLog = log.New(io.Discard, "", 0)
+		server.StartTLS()
+		t.Cleanup(server.Close)
+
+		pool := base.Clone()
+		pool.AddCert(leaf1)
+
+		loadCount := 0
+		rootCALoader := func() (*x509.CertPool, error) {
+			loadCount++
+			return pool, nil
+		}
+
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoader: func() (*tls.Certificate, error) {
+				return generateTLSKeyPair()
+			},
+			SecondaryLoader: func() (*tls.Certificate, error) {
+				return generateTLSKeyPair()
+			},
+			BaseTLS:      &tls.Config{InsecureSkipVerify: true},
+			RootCALoader: rootCALoader,
+		})
+
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+
+		req2, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+		resp2, err := transport.RoundTrip(req2)
+		require.NoError(t, err)
+		require.NoError(t, resp2.Body.Close())
+		assert.Equal(t, 2, loadCount, "unchanged system-derived pool should still be detected as unchanged")
+
+		// Swap in a pool that differs only in which cert was explicitly
+		// added on top of the same system roots: poolsEqual must still catch
+		// this on its own, with no RefreshRoots call, unlike a
+		// Subjects()-based fingerprint which silently ignores anything
+		// contributed by SystemCertPool.
+		pool = base.Clone()
+		pool.AddCert(leaf2)
+
+		req3, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+		resp3, err := transport.RoundTrip(req3)
+		require.NoError(t, err)
+		require.NoError(t, resp3.Body.Close())
+
+		req4, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+		require.NoError(t, err)
+		resp4, err := transport.RoundTrip(req4)
+		require.NoError(t, err)
+		require.NoError(t, resp4.Body.Close())
+		// req3: one load to compare, one to rebuild; req4: one load to compare.
+		assert.Equal(t, 5, loadCount, "the cached transport should have been rebuilt for the new pool")
+	})
+}
@@ -0,0 +1,160 @@
+package dynamictls
+
+import (
+	"context"
+	"crypto/x509"
+	"sync/atomic"
+	"time"
+)
+
+// minRotationRetry bounds how soon a failed proactive reload is retried, so a
+// persistently broken loader doesn't spin.
+const minRotationRetry = time.Minute
+
+// Start begins a background rotation loop that watches the primary and
+// secondary certificates and proactively re-invokes their loaders as each
+// certificate approaches expiry. A certificate is reloaded RenewBefore
+// before its NotAfter, or, if RenewBefore is zero, once RenewFraction of its
+// lifetime (NotBefore..NotAfter) has elapsed.
+//
+// Start loads any certificate that hasn't been loaded yet so it can schedule
+// the first rotation. It returns immediately; rotation happens on
+// independent timers until ctx is canceled or Stop is called. Calling Start
+// more than once without an intervening Stop is a no-op.
+func (t *Transport) Start(ctx context.Context) error {
+	if !t.running.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	ctx, t.cancel = context.WithCancel(ctx)
+
+	// Slots driven by a LoaderFunc resolve a certificate fresh on every
+	// handshake rather than caching one to watch, so there's nothing for the
+	// rotation loop to schedule.
+	if t.pLoader != nil && t.pLoaderFunc == nil {
+		if err := t.armRotation(ctx, "primary", t.pLoader, &t.pState, &t.pTimer); err != nil {
+			t.running.Store(false)
+			t.cancel()
+			return err
+		}
+	}
+	if t.sLoader != nil && t.sLoaderFunc == nil {
+		if err := t.armRotation(ctx, "secondary", t.sLoader, &t.sState, &t.sTimer); err != nil {
+			t.running.Store(false)
+			t.cancel()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Stop cancels the rotation loop started by Start. It is safe to call Stop
+// even if Start was never called, or more than once.
+func (t *Transport) Stop() {
+	if !t.running.CompareAndSwap(true, false) {
+		return
+	}
+
+	t.cancel()
+
+	if timer := t.pTimer.Swap(nil); timer != nil {
+		timer.Stop()
+	}
+	if timer := t.sTimer.Swap(nil); timer != nil {
+		timer.Stop()
+	}
+}
+
+// armRotation ensures which has a loaded certState, notifies OnRotate for
+// the initial load, and schedules the timer that will reload it again as it
+// approaches expiry.
+func (t *Transport) armRotation(ctx context.Context, which string, l Loader, state *atomic.Pointer[certState], timer *atomic.Pointer[time.Timer]) error {
+	t.mu.Lock()
+	cs := state.Load()
+	if cs == nil {
+		var err error
+		cs, err = t.buildCertState(which, l, nil)
+		if err != nil {
+			t.mu.Unlock()
+			if t.onRotate != nil {
+				t.onRotate(which, nil, err)
+			}
+			return err
+		}
+		state.Store(cs)
+	}
+	t.mu.Unlock()
+
+	if t.onRotate != nil {
+		t.onRotate(which, cs.cert, nil)
+	}
+
+	t.scheduleNext(ctx, which, l, state, timer, cs.leaf)
+	return nil
+}
+
+// renewAt computes when leaf should be proactively reloaded.
+func (t *Transport) renewAt(leaf *x509.Certificate) time.Time {
+	if t.renewBefore > 0 {
+		return leaf.NotAfter.Add(-t.renewBefore)
+	}
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+	return leaf.NotBefore.Add(time.Duration(float64(lifetime) * t.renewFraction))
+}
+
+// scheduleNext arms timer to fire at leaf's renewal point. If leaf is nil
+// (the loader returned a certificate whose leaf couldn't be parsed),
+// rotation can't be scheduled and the certificate is left in place
+// indefinitely.
+func (t *Transport) scheduleNext(ctx context.Context, which string, l Loader, state *atomic.Pointer[certState], timer *atomic.Pointer[time.Timer], leaf *x509.Certificate) {
+	if leaf == nil {
+		return
+	}
+
+	delay := time.Until(t.renewAt(leaf))
+	if delay < 0 {
+		delay = 0
+	}
+
+	timer.Store(time.AfterFunc(delay, func() {
+		t.rotate(ctx, which, l, state, timer)
+	}))
+}
+
+// rotate reloads which's certificate, swaps it into state atomically, closes
+// the old *http.Transport's idle connections so in-flight requests on it are
+// unaffected, and schedules the next rotation. A loader failure is reported
+// via OnRotate and retried after minRotationRetry, leaving the previous,
+// still-valid certificate in place.
+func (t *Transport) rotate(ctx context.Context, which string, l Loader, state *atomic.Pointer[certState], timer *atomic.Pointer[time.Timer]) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	t.mu.Lock()
+	old := state.Load()
+	cs, err := t.buildCertState(which, l, nil)
+	if err != nil {
+		t.mu.Unlock()
+		if t.onRotate != nil {
+			t.onRotate(which, nil, err)
+		}
+		timer.Store(time.AfterFunc(minRotationRetry, func() {
+			t.rotate(ctx, which, l, state, timer)
+		}))
+		return
+	}
+	state.Store(cs)
+	t.mu.Unlock()
+
+	if old != nil {
+		old.transport.CloseIdleConnections()
+	}
+
+	if t.onRotate != nil {
+		t.onRotate(which, cs.cert, nil)
+	}
+
+	t.scheduleNext(ctx, which, l, state, timer, cs.leaf)
+}
@@ -0,0 +1,125 @@
+package dynamictls_test
+
+import (
+	"context"
+	"crypto/tls"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vladyslavpavlenko/dynamictls"
+)
+
+func TestTransport_Rotation(main *testing.T) {
+	main.Run("StartLoadsAndNotifiesOnRotate", func(t *testing.T) {
+		var events []string
+
+		pLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+		sLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoader:   pLoader,
+			SecondaryLoader: sLoader,
+			BaseTLS:         &tls.Config{InsecureSkipVerify: true},
+			OnRotate: func(which string, cert *tls.Certificate, err error) {
+				require.NoError(t, err)
+				require.NotNil(t, cert)
+				events = append(events, which)
+			},
+		})
+		t.Cleanup(transport.Stop)
+
+		require.NoError(t, transport.Start(context.Background()))
+
+		assert.ElementsMatch(t, []string{"primary", "secondary"}, events)
+	})
+
+	main.Run("StartIsIdempotent", func(t *testing.T) {
+		pLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+		sLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoader:   pLoader,
+			SecondaryLoader: sLoader,
+			BaseTLS:         &tls.Config{InsecureSkipVerify: true},
+		})
+		t.Cleanup(transport.Stop)
+
+		require.NoError(t, transport.Start(context.Background()))
+		require.NoError(t, transport.Start(context.Background()))
+
+		transport.Stop()
+		transport.Stop()
+	})
+
+	main.Run("ReloadsCertificateWhenRenewalTimerFires", func(t *testing.T) {
+		var primaryLoads atomic.Int32
+		var rotateEvents atomic.Int32
+
+		pLoader := func() (*tls.Certificate, error) {
+			primaryLoads.Add(1)
+			return generateTLSKeyPair()
+		}
+		sLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoader:   pLoader,
+			SecondaryLoader: sLoader,
+			BaseTLS:         &tls.Config{InsecureSkipVerify: true},
+			// generateTLSKeyPair's certs live for 1h; setting RenewBefore
+			// just short of that schedules the proactive reload a fraction
+			// of a second out, so the test can wait for it instead of only
+			// ever covering the initial, unscheduled load.
+			RenewBefore: time.Hour - 150*time.Millisecond,
+			OnRotate: func(which string, cert *tls.Certificate, err error) {
+				require.NoError(t, err)
+				require.NotNil(t, cert)
+				rotateEvents.Add(1)
+			},
+		})
+		t.Cleanup(transport.Stop)
+
+		require.NoError(t, transport.Start(context.Background()))
+		require.Equal(t, int32(1), primaryLoads.Load(), "Start should have loaded the primary certificate once already")
+
+		require.Eventually(t, func() bool {
+			return primaryLoads.Load() >= 2
+		}, 3*time.Second, 10*time.Millisecond,
+			"the rotation timer should have reloaded the primary certificate again before it expired")
+
+		// Two initial loads (primary, secondary) plus at least one proactive
+		// rotation.
+		assert.GreaterOrEqual(t, rotateEvents.Load(), int32(3))
+	})
+
+	main.Run("StopCancelsRotationContext", func(t *testing.T) {
+		pLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+		sLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+
+		transport := dynamictls.New(dynamictls.Config{
+			PrimaryLoader:   pLoader,
+			SecondaryLoader: sLoader,
+			BaseTLS:         &tls.Config{InsecureSkipVerify: true},
+			RenewBefore:     time.Hour,
+		})
+
+		require.NoError(t, transport.Start(context.Background()))
+		transport.Stop()
+	})
+}
@@ -0,0 +1,139 @@
+package dynamictls
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Certificate returns the certificate a server-side [tls.Config] should
+// present for the current handshake, applying the same FailurePolicy-driven
+// failover as RoundTrip: the loader the policy currently prefers is tried
+// first, falling back to the other on failure. hello is the peer's
+// ClientHelloInfo when the caller has one (GetCertificate does;
+// GetClientCertificate's CertificateRequestInfo doesn't, so it passes nil);
+// either way it and ctx are forwarded to a PrimaryLoaderFunc/SecondaryLoaderFunc
+// if one is configured in place of the plain Loader.
+func (t *Transport) Certificate(ctx context.Context, hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	first, second := WhichPrimary, WhichSecondary
+	if t.failurePolicy.ShouldPreferSecondary() {
+		first, second = WhichSecondary, WhichPrimary
+	}
+
+	cert, err := t.loadWhich(first, ctx, hello)
+	t.failurePolicy.OnResult(first, err)
+	if err == nil {
+		return cert, nil
+	}
+
+	t.recordFailover(first, second, err)
+
+	cert, err = t.loadWhich(second, ctx, hello)
+	t.failurePolicy.OnResult(second, err)
+	return cert, err
+}
+
+// contextOrBackground substitutes context.Background for a nil context.
+// ClientHelloInfo.Context and CertificateRequestInfo.Context both return nil
+// when the info wasn't produced by a crypto/tls handshake, e.g. one built by
+// hand and passed straight to GetCertificate.
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+func (t *Transport) loadWhich(which Which, ctx context.Context, hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if which == WhichPrimary {
+		if t.pLoaderFunc != nil {
+			return t.pLoaderFunc(ctx, hello)
+		}
+		return t.pLoader()
+	}
+	if t.sLoaderFunc != nil {
+		return t.sLoaderFunc(ctx, hello)
+	}
+	return t.sLoader()
+}
+
+// NewServerTLSConfig builds a *tls.Config for terminating inbound TLS
+// connections from cfg's loaders. GetCertificate and GetClientCertificate
+// both resolve to the primary/secondary failover implemented by
+// [Transport.Certificate]; GetClientCertificate is populated so the same
+// Config can also drive an outbound mTLS client via [tls.Dial], though unlike
+// GetCertificate, [tls.Dial] has no per-dial hook equivalent to
+// GetConfigForClient, so cfg.RootCALoader (below) is only applied once, at
+// construction time, for that path.
+//
+// If cfg.RootCALoader is set, it's loaded once here and used for base.RootCAs
+// instead of cfg.BaseTLS.RootCAs. This is a one-time snapshot, not dynamic
+// rotation: a [tls.Dial] using this Config has no per-dial callback to refresh
+// it from, unlike the inbound handshake's GetConfigForClient. RoundTrip's own
+// root rotation (see RootCALoader's doc) only affects Transport's client
+// path, which NewServerTLSConfig doesn't use.
+//
+// If cfg.ClientCALoader is set, the returned Config requires and verifies a
+// client certificate, reloading the CA pool on every handshake via
+// GetConfigForClient so server-side CA rotation takes effect without
+// restarting the listener.
+func NewServerTLSConfig(cfg Config) *tls.Config {
+	transport := New(cfg)
+
+	base := &tls.Config{}
+	if cfg.BaseTLS != nil {
+		base = cfg.BaseTLS.Clone()
+	}
+
+	if cfg.RootCALoader != nil {
+		if pool, err := cfg.RootCALoader(); err == nil {
+			base.RootCAs = pool
+		}
+	}
+
+	base.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return transport.Certificate(contextOrBackground(hello.Context()), hello)
+	}
+	base.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return transport.Certificate(contextOrBackground(cri.Context()), nil)
+	}
+
+	if cfg.ClientCALoader != nil {
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+		base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			pool, err := cfg.ClientCALoader()
+			if err != nil {
+				return nil, fmt.Errorf("load client CAs: %w", err)
+			}
+
+			clone := base.Clone()
+			clone.ClientCAs = pool
+			clone.GetConfigForClient = nil
+			return clone, nil
+		}
+	}
+
+	return base
+}
+
+// NewListener wraps inner so every accepted connection is served with the
+// *tls.Config built by NewServerTLSConfig, giving the listener the same
+// certificate rotation and failover as the client Transport.
+func NewListener(inner net.Listener, cfg Config) net.Listener {
+	return tls.NewListener(inner, NewServerTLSConfig(cfg))
+}
+
+// BootstrapServer wires cfg's dynamic loaders into base by setting
+// base.TLSConfig, mirroring smallstep's BootstrapServer/BootstrapServerWithMTLS
+// helpers. Set cfg.ClientCALoader to additionally require and verify client
+// certificates, the equivalent of BootstrapServerWithMTLS.
+func BootstrapServer(base *http.Server, cfg Config) error {
+	if base == nil {
+		return fmt.Errorf("dynamictls: base server is nil")
+	}
+
+	base.TLSConfig = NewServerTLSConfig(cfg)
+	return nil
+}
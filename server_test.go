@@ -0,0 +1,138 @@
+package dynamictls_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vladyslavpavlenko/dynamictls"
+)
+
+func TestNewServerTLSConfig(main *testing.T) {
+	main.Run("FailsOverToSecondaryAfterThreshold", func(t *testing.T) {
+		pCallCount := 0
+		pLoader := func() (*tls.Certificate, error) {
+			pCallCount++
+			return nil, errors.New("primary unavailable")
+		}
+		sLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+
+		tlsConfig := dynamictls.NewServerTLSConfig(dynamictls.Config{
+			PrimaryLoader:   pLoader,
+			SecondaryLoader: sLoader,
+			Threshold:       1,
+		})
+
+		cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+		require.NoError(t, err)
+		assert.NotNil(t, cert)
+		assert.Equal(t, 1, pCallCount)
+	})
+
+	main.Run("RequiresClientCertWhenClientCALoaderSet", func(t *testing.T) {
+		pLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+
+		tlsConfig := dynamictls.NewServerTLSConfig(dynamictls.Config{
+			PrimaryLoader:   pLoader,
+			SecondaryLoader: pLoader,
+			ClientCALoader: func() (*x509.CertPool, error) {
+				return x509.NewCertPool(), nil
+			},
+		})
+
+		assert.Equal(t, tls.RequireAndVerifyClientCert, tlsConfig.ClientAuth)
+
+		cfg, err := tlsConfig.GetConfigForClient(&tls.ClientHelloInfo{})
+		require.NoError(t, err)
+		assert.NotNil(t, cfg.ClientCAs)
+	})
+
+	main.Run("AppliesRootCALoaderToOutboundMTLSConfig", func(t *testing.T) {
+		pLoader := func() (*tls.Certificate, error) {
+			return generateTLSKeyPair()
+		}
+
+		pool := x509.NewCertPool()
+		cert, err := generateTLSKeyPair()
+		require.NoError(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+		pool.AddCert(leaf)
+
+		tlsConfig := dynamictls.NewServerTLSConfig(dynamictls.Config{
+			PrimaryLoader:   pLoader,
+			SecondaryLoader: pLoader,
+			RootCALoader: func() (*x509.CertPool, error) {
+				return pool, nil
+			},
+		})
+
+		require.NotNil(t, tlsConfig.RootCAs)
+		assert.True(t, tlsConfig.RootCAs.Equal(pool))
+	})
+
+	main.Run("UsesLoaderFuncWhenConfigured", func(t *testing.T) {
+		var gotHello *tls.ClientHelloInfo
+		pLoaderFunc := func(ctx context.Context, hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			gotHello = hello
+			require.NotNil(t, ctx)
+			return generateTLSKeyPair()
+		}
+
+		// Per PrimaryLoaderFunc's doc, setting it replaces PrimaryLoader
+		// entirely, so PrimaryLoader is legitimately left nil here.
+		tlsConfig := dynamictls.NewServerTLSConfig(dynamictls.Config{
+			PrimaryLoaderFunc:   pLoaderFunc,
+			SecondaryLoaderFunc: pLoaderFunc,
+		})
+
+		hello := &tls.ClientHelloInfo{ServerName: "example.com"}
+		cert, err := tlsConfig.GetCertificate(hello)
+		require.NoError(t, err)
+		assert.NotNil(t, cert)
+		assert.Same(t, hello, gotHello)
+	})
+}
+
+func TestNewListener(t *testing.T) {
+	pLoader := func() (*tls.Certificate, error) {
+		return generateTLSKeyPair()
+	}
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ln := dynamictls.NewListener(inner, dynamictls.Config{
+		PrimaryLoader:   pLoader,
+		SecondaryLoader: pLoader,
+	})
+	t.Cleanup(func() { _ = ln.Close() })
+
+	assert.Equal(t, inner.Addr(), ln.Addr())
+}
+
+func TestBootstrapServer(t *testing.T) {
+	pLoader := func() (*tls.Certificate, error) {
+		return generateTLSKeyPair()
+	}
+
+	server := &http.Server{}
+	err := dynamictls.BootstrapServer(server, dynamictls.Config{
+		PrimaryLoader:   pLoader,
+		SecondaryLoader: pLoader,
+	})
+	require.NoError(t, err)
+	assert.NotNil(t, server.TLSConfig)
+
+	require.Error(t, dynamictls.BootstrapServer(nil, dynamictls.Config{}))
+}
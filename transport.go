@@ -3,33 +3,88 @@ package dynamictls
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
 )
 
 const threshold = 3
 
 type Loader func() (*tls.Certificate, error)
 
+// LoaderFunc is a context-aware certificate loader: unlike Loader, it
+// receives the handshake's context (so cancellation and deadlines from the
+// originating request propagate) and the peer's hello, so the certificate
+// served can depend on per-request identity (a SPIFFE selector, a tenant ID
+// pulled from the request context, and so on). Where a Loader is baked into
+// the cached *http.Transport at load time, a LoaderFunc is invoked fresh for
+// every handshake via tls.Config.GetClientCertificate, so a single cached
+// transport can serve many identities without ever being invalidated.
+type LoaderFunc func(ctx context.Context, hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+
+// certState bundles an http.Transport with the certificate it was built from,
+// so a rotation loop can inspect the leaf without re-parsing it on every tick.
+type certState struct {
+	transport *http.Transport
+	cert      *tls.Certificate
+	leaf      *x509.Certificate
+	rootsPool *x509.CertPool
+}
+
 type Transport struct {
-	pLoader Loader
-	sLoader Loader
-	baseTLS *tls.Config
+	pLoader     Loader
+	sLoader     Loader
+	pLoaderFunc LoaderFunc
+	sLoaderFunc LoaderFunc
+	baseTLS     *tls.Config
 
-	pFailures atomic.Uint32
-	threshold uint32
+	failurePolicy FailurePolicy
+	pSuccesses    atomic.Uint64
+	pFailures     atomic.Uint64
+	sSuccesses    atomic.Uint64
+	sFailures     atomic.Uint64
 
-	pTransport atomic.Pointer[http.Transport]
-	sTransport atomic.Pointer[http.Transport]
-	mu         sync.RWMutex
+	pState atomic.Pointer[certState]
+	sState atomic.Pointer[certState]
+	mu     sync.RWMutex
 
 	// Transport configuration
 	dialContext     func(ctx context.Context, network, addr string) (net.Conn, error)
 	idleConnTimeout time.Duration
+
+	// Root CA rotation, see RootCALoader.
+	rootCALoader   func() (*x509.CertPool, error)
+	clientCALoader func() (*x509.CertPool, error)
+
+	// HTTP/2, see Config.EnableHTTP2.
+	enableHTTP2 bool
+	nextProtos  []string
+
+	// Rotation configuration, see Start.
+	renewBefore   time.Duration
+	renewFraction float64
+	onRotate      func(which string, cert *tls.Certificate, err error)
+
+	// Observability, see Config.Metrics, Config.TracerProvider and
+	// Config.Logger.
+	metrics Metrics
+	tracer  trace.Tracer
+	logger  *slog.Logger
+
+	cancel  context.CancelFunc
+	running atomic.Bool
+	pTimer  atomic.Pointer[time.Timer]
+	sTimer  atomic.Pointer[time.Timer]
 }
 
 type Config struct {
@@ -39,13 +94,33 @@ type Config struct {
 	// SecondaryLoader is the secondary certificate loader.
 	SecondaryLoader Loader
 
+	// PrimaryLoaderFunc, if set, replaces PrimaryLoader with a context-aware
+	// loader invoked per handshake via tls.Config.GetClientCertificate
+	// instead of being baked into the cached *http.Transport. See LoaderFunc.
+	PrimaryLoaderFunc LoaderFunc
+
+	// SecondaryLoaderFunc is the context-aware counterpart of
+	// SecondaryLoader, see PrimaryLoaderFunc.
+	SecondaryLoaderFunc LoaderFunc
+
 	// BaseTLS is the base TLS configuration.
 	BaseTLS *tls.Config
 
 	// Threshold is the number of consecutive failures before a secondary
-	// certificate is tried. The default is 3.
+	// certificate is tried. The default is 3. Deprecated: set
+	// CircuitBreaker.ConsecutiveFailures instead; Threshold is still honored
+	// as its default when CircuitBreaker.ConsecutiveFailures is zero and
+	// FailurePolicy is nil.
 	Threshold uint32
 
+	// FailurePolicy, if set, overrides the default circuit breaker entirely.
+	// See FailurePolicy.
+	FailurePolicy FailurePolicy
+
+	// CircuitBreaker tunes the default FailurePolicy. Ignored if
+	// FailurePolicy is set.
+	CircuitBreaker CircuitBreakerConfig
+
 	// DialContext specifies the dial function for creating unencrypted TCP connections.
 	// If nil, the default dialer is used.
 	// By default, inherited from [http.DefaultTransport].
@@ -56,76 +131,256 @@ type Config struct {
 	// Zero means no limit.
 	// By default, inherited from [http.DefaultTransport].
 	IdleConnTimeout time.Duration
+
+	// RenewBefore is the fixed duration before a certificate's NotAfter at
+	// which Start proactively reloads it. Takes precedence over RenewFraction
+	// when non-zero.
+	RenewBefore time.Duration
+
+	// RenewFraction is the fraction of a certificate's lifetime (NotAfter
+	// minus NotBefore) after which Start proactively reloads it. The default
+	// is 2/3, matching the behavior of smallstep's bootstrap client.
+	RenewFraction float64
+
+	// OnRotate, if set, is called by Start every time a certificate is
+	// (re)loaded: once for the initial load and again after every proactive
+	// renewal. err is non-nil if the loader failed, in which case cert is nil
+	// and the previous, still-valid certificate remains in use.
+	OnRotate func(which string, cert *tls.Certificate, err error)
+
+	// RootCALoader, if set, loads the pool of root CAs used to verify the
+	// remote server's certificate, overriding BaseTLS.RootCAs. RoundTrip
+	// reloads the pool on every call and compares it with the one baked into
+	// the cached *http.Transport (see poolsEqual); on mismatch, the cached
+	// transport is invalidated and rebuilt with the fresh pool. This allows
+	// server-side Root CA rotation without restarting the process.
+	RootCALoader func() (*x509.CertPool, error)
+
+	// ClientCALoader, if set, loads the pool of CAs used to verify a peer's
+	// client certificate when this Config drives a server-side TLS listener
+	// (see NewServerTLSConfig). It has no effect on outbound RoundTrips.
+	ClientCALoader func() (*x509.CertPool, error)
+
+	// EnableHTTP2 configures the cloned transport for HTTP/2 via
+	// http2.ConfigureTransport, and sets NextProtos on the TLS config so ALPN
+	// actually negotiates it. Nil defaults to enabled; a non-nil pointer to
+	// false disables it.
+	//
+	// http.DefaultTransport.Clone copies ForceAttemptHTTP2, which would let
+	// http.Transport set up HTTP/2 on its own despite the custom
+	// TLSClientConfig. When EnableHTTP2 is enabled, ConfigureTransport is
+	// called explicitly instead; when it's disabled, newTransport clears
+	// ForceAttemptHTTP2 and installs an empty TLSNextProto so connections
+	// stay on HTTP/1.1.
+	EnableHTTP2 *bool
+
+	// NextProtos overrides the ALPN protocol list advertised during the TLS
+	// handshake when EnableHTTP2 is enabled; the default there is
+	// []string{"h2", "http/1.1"}. When EnableHTTP2 is false, NextProtos is
+	// ignored and BaseTLS.NextProtos (if any) is left untouched.
+	NextProtos []string
+
+	// Metrics, if set, receives certificate load, handshake, failover and
+	// cert-expiry observations. See Metrics.
+	Metrics Metrics
+
+	// TracerProvider, if set, is used to wrap each RoundTrip attempt against
+	// a loader, including any certificate load it triggers, in a span
+	// ("dynamictls.do") with attributes recording which loader ran and
+	// whether the cached *http.Transport was reused. Loads made by Start's
+	// rotation loop and by Certificate are not traced.
+	TracerProvider trace.TracerProvider
+
+	// Logger, if set, receives structured events for every certificate
+	// rotation, failover to the other loader, and circuit breaker state
+	// transition (e.g. a threshold trip). Useful for dashboards and alerts
+	// that shouldn't depend on instrumenting the loaders themselves.
+	Logger *slog.Logger
 }
 
+const defaultRenewFraction = 2.0 / 3.0
+
 func New(cfg Config) *Transport {
 	if cfg.Threshold == 0 {
 		cfg.Threshold = threshold
 	}
+	if cfg.RenewFraction == 0 {
+		cfg.RenewFraction = defaultRenewFraction
+	}
+
+	failurePolicy := cfg.FailurePolicy
+	if failurePolicy == nil {
+		cbCfg := cfg.CircuitBreaker
+		if cbCfg.ConsecutiveFailures == 0 {
+			cbCfg.ConsecutiveFailures = cfg.Threshold
+		}
+		failurePolicy = newCircuitBreaker(cbCfg)
+	}
+	if cfg.Logger != nil {
+		failurePolicy = &loggingPolicy{FailurePolicy: failurePolicy, logger: cfg.Logger}
+	}
+
+	var tracer trace.Tracer
+	if cfg.TracerProvider != nil {
+		tracer = cfg.TracerProvider.Tracer("dynamictls")
+	}
 
 	return &Transport{
 		pLoader:         cfg.PrimaryLoader,
 		sLoader:         cfg.SecondaryLoader,
+		pLoaderFunc:     cfg.PrimaryLoaderFunc,
+		sLoaderFunc:     cfg.SecondaryLoaderFunc,
 		baseTLS:         cfg.BaseTLS,
-		threshold:       cfg.Threshold,
+		failurePolicy:   failurePolicy,
 		dialContext:     cfg.DialContext,
 		idleConnTimeout: cfg.IdleConnTimeout,
+		renewBefore:     cfg.RenewBefore,
+		renewFraction:   cfg.RenewFraction,
+		onRotate:        cfg.OnRotate,
+		rootCALoader:    cfg.RootCALoader,
+		clientCALoader:  cfg.ClientCALoader,
+		enableHTTP2:     cfg.EnableHTTP2 == nil || *cfg.EnableHTTP2,
+		nextProtos:      cfg.NextProtos,
+		metrics:         cfg.Metrics,
+		tracer:          tracer,
+		logger:          cfg.Logger,
 	}
 }
 
 // RoundTrip implements http.RoundTripper.
-// It tries the primary certificate first, then secondary if primary fails.
-// After threshold consecutive failures, it tries secondary first.
+// It asks failurePolicy which loader to try first, falls back to the other
+// on failure, and reports both outcomes back to the policy.
 func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
-	trySecondaryFirst := t.pFailures.Load() >= t.threshold
-
-	if trySecondaryFirst {
-		// Try secondary first
-		resp, err := t.do(req, t.sLoader, &t.sTransport)
-		if err == nil {
-			return resp, nil
-		}
-
-		// SecondaryLoader failed, try primary
-		resp, err = t.do(req, t.pLoader, &t.pTransport)
-		if err == nil {
-			// PrimaryLoader succeeded, reset failure counter
-			t.pFailures.Store(0)
-			return resp, nil
-		}
-		return nil, err
+	first, second := WhichPrimary, WhichSecondary
+	if t.failurePolicy.ShouldPreferSecondary() {
+		first, second = WhichSecondary, WhichPrimary
 	}
 
-	// Try primary first
-	resp, err := t.do(req, t.pLoader, &t.pTransport)
+	resp, err := t.tryWhich(req, first)
+	t.failurePolicy.OnResult(first, err)
 	if err == nil {
-		t.pFailures.Store(0)
 		return resp, nil
 	}
 
-	// PrimaryLoader failed
-	t.pFailures.Add(1)
-	return t.do(req, t.sLoader, &t.sTransport)
+	t.recordFailover(first, second, err)
+
+	resp, err = t.tryWhich(req, second)
+	t.failurePolicy.OnResult(second, err)
+	return resp, err
 }
 
-func (t *Transport) do(req *http.Request, l Loader, c *atomic.Pointer[http.Transport]) (*http.Response, error) {
-	transport := c.Load()
-	if transport != nil {
-		return transport.RoundTrip(req)
+// tryWhich performs a RoundTrip against which's loader and bumps its
+// success/failure counter for Stats.
+func (t *Transport) tryWhich(req *http.Request, which Which) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+	switch which {
+	case WhichPrimary:
+		resp, err = t.do(req, "primary", t.pLoader, t.pLoaderFunc, &t.pState)
+	default:
+		resp, err = t.do(req, "secondary", t.sLoader, t.sLoaderFunc, &t.sState)
+	}
+
+	switch {
+	case which == WhichPrimary && err == nil:
+		t.pSuccesses.Add(1)
+	case which == WhichPrimary:
+		t.pFailures.Add(1)
+	case err == nil:
+		t.sSuccesses.Add(1)
+	default:
+		t.sFailures.Add(1)
+	}
+
+	return resp, err
+}
+
+// do wraps doUncounted in a "dynamictls.do" span when a TracerProvider is
+// configured, recording which loader ran and whether the cached
+// *http.Transport was reused.
+func (t *Transport) do(req *http.Request, which string, l Loader, lf LoaderFunc, c *atomic.Pointer[certState]) (*http.Response, error) {
+	if t.tracer == nil {
+		resp, _, err := t.doUncounted(req, which, l, lf, c)
+		return resp, err
+	}
+
+	ctx, span := t.tracer.Start(req.Context(), "dynamictls.do",
+		trace.WithAttributes(attribute.String("dynamictls.which", which)))
+	defer span.End()
+
+	resp, cacheHit, err := t.doUncounted(req.WithContext(ctx), which, l, lf, c)
+	span.SetAttributes(attribute.Bool("dynamictls.cache_hit", cacheHit))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return resp, err
+}
+
+func (t *Transport) doUncounted(req *http.Request, which string, l Loader, lf LoaderFunc, c *atomic.Pointer[certState]) (*http.Response, bool, error) {
+	cs := c.Load()
+	if cs != nil {
+		if t.rootCALoader == nil || t.rootsUnchanged(cs) {
+			resp, err := cs.transport.RoundTrip(req)
+			return resp, true, err
+		}
+		// Roots changed since cs was built: invalidate and fall through to
+		// rebuild, but only if nobody else already did.
+		c.CompareAndSwap(cs, nil)
 	}
 
 	t.mu.Lock()
 
-	transport = c.Load()
-	if transport != nil {
+	cs = c.Load()
+	if cs != nil {
 		t.mu.Unlock()
-		return transport.RoundTrip(req)
+		resp, err := cs.transport.RoundTrip(req)
+		return resp, true, err
 	}
 
-	cert, err := l()
+	start := time.Now()
+	cs, err := t.buildCertState(which, l, lf)
 	if err != nil {
 		t.mu.Unlock()
-		return nil, fmt.Errorf("load certificate: %w", err)
+		return nil, false, err
+	}
+
+	c.Store(cs)
+	t.mu.Unlock()
+
+	resp, err := cs.transport.RoundTrip(req)
+	t.recordHandshake(which, time.Since(start))
+	return resp, false, err
+}
+
+// buildCertState wraps l (or, if lf is set, a per-handshake wrapper around
+// lf) in a certState: a freshly cloned *http.Transport pinned to that
+// loader, plus the parsed leaf so callers (notably the rotation loop in
+// rotation.go) can inspect NotBefore/NotAfter without re-parsing. which
+// identifies the slot ("primary" or "secondary") for error messages and the
+// GetClientCertificate wrapper.
+func (t *Transport) buildCertState(which string, l Loader, lf LoaderFunc) (*certState, error) {
+	cs, err := t.buildCertStateUninstrumented(which, l, lf)
+	t.recordCertLoad(which, cs, err)
+	return cs, err
+}
+
+func (t *Transport) buildCertStateUninstrumented(which string, l Loader, lf LoaderFunc) (*certState, error) {
+	if lf != nil {
+		return t.buildContextCertState(which, lf)
+	}
+
+	cert, err := l()
+	if err != nil {
+		return nil, fmt.Errorf("load %s certificate: %w", which, err)
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil && len(cert.Certificate) > 0 {
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("parse %s leaf certificate: %w", which, err)
+		}
 	}
 
 	var baseTLS *tls.Config
@@ -137,7 +392,37 @@ func (t *Transport) do(req *http.Request, l Loader, c *atomic.Pointer[http.Trans
 
 	baseTLS.Certificates = []tls.Certificate{*cert}
 
-	transport = http.DefaultTransport.(*http.Transport).Clone()
+	var rootsPool *x509.CertPool
+	if t.rootCALoader != nil {
+		pool, err := t.rootCALoader()
+		if err != nil {
+			return nil, fmt.Errorf("load root CAs: %w", err)
+		}
+		baseTLS.RootCAs = pool
+		rootsPool = pool
+	}
+
+	if t.enableHTTP2 {
+		if len(t.nextProtos) > 0 {
+			baseTLS.NextProtos = t.nextProtos
+		} else {
+			baseTLS.NextProtos = []string{"h2", "http/1.1"}
+		}
+	}
+
+	transport, err := t.newTransport(baseTLS)
+	if err != nil {
+		return nil, err
+	}
+
+	return &certState{transport: transport, cert: cert, leaf: leaf, rootsPool: rootsPool}, nil
+}
+
+// newTransport clones http.DefaultTransport, installs baseTLS, applies the
+// dialer/idle-timeout overrides and HTTP/2 configuration shared by every
+// certState, and returns the result.
+func (t *Transport) newTransport(baseTLS *tls.Config) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
 	transport.TLSClientConfig = baseTLS
 
 	if t.dialContext != nil {
@@ -147,18 +432,56 @@ func (t *Transport) do(req *http.Request, l Loader, c *atomic.Pointer[http.Trans
 		transport.IdleConnTimeout = t.idleConnTimeout
 	}
 
-	c.Store(transport)
-	t.mu.Unlock()
+	if t.enableHTTP2 {
+		// http.DefaultTransport.Clone keeps its original TLSNextProto, which
+		// is tied to the pre-clone TLSClientConfig's *tls.Config identity and
+		// won't upgrade connections made with the one we just installed.
+		// ConfigureTransport rebuilds TLSNextProto["h2"] against the new
+		// TLSClientConfig.
+		transport.TLSNextProto = nil
+		if err := http2.ConfigureTransport(transport); err != nil {
+			return nil, fmt.Errorf("configure http2: %w", err)
+		}
+	} else {
+		// http.DefaultTransport.Clone also copies ForceAttemptHTTP2, which
+		// makes http.Transport configure its own h2 upgrade the first time it
+		// sees a TLSClientConfig with a nil NextProtos, regardless of us never
+		// calling ConfigureTransport. Without this, disabling EnableHTTP2 is a
+		// no-op: the handshake still negotiates h2.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
 
-	return transport.RoundTrip(req)
+	return transport, nil
+}
+
+// rootsUnchanged reports whether the root CA pool currently returned by
+// RootCALoader still matches the one baked into cs.
+func (t *Transport) rootsUnchanged(cs *certState) bool {
+	pool, err := t.rootCALoader()
+	if err != nil {
+		// Can't tell; keep using the cached transport rather than failing
+		// the request over a transient loader error.
+		return true
+	}
+	return poolsEqual(pool, cs.rootsPool)
 }
 
 // RefreshCertificates forces a refresh of both certificates on the next request
 func (t *Transport) RefreshCertificates() {
-	if old := t.pTransport.Swap(nil); old != nil {
-		old.CloseIdleConnections()
+	if old := t.pState.Swap(nil); old != nil {
+		old.transport.CloseIdleConnections()
 	}
-	if old := t.sTransport.Swap(nil); old != nil {
-		old.CloseIdleConnections()
+	if old := t.sState.Swap(nil); old != nil {
+		old.transport.CloseIdleConnections()
 	}
 }
+
+// RefreshRoots forces both cached transports to rebuild their root CA pool
+// from RootCALoader on the next request. It's equivalent to RefreshCertificates
+// but named for the common case of reacting to an out-of-band root rotation
+// signal rather than a leaf certificate change; RoundTrip itself detects root
+// changes automatically, so most callers won't need this.
+func (t *Transport) RefreshRoots() {
+	t.RefreshCertificates()
+}